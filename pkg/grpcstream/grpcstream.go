@@ -0,0 +1,181 @@
+// Package grpcstream provides a gRPC-based alternative to
+// stream.HTTPReaderFactory, backed by the Tailer service's server-streaming
+// Tail RPC. It gives callers proper flow control, cancellation propagation,
+// keepalives, and TLS/mTLS, all courtesy of grpc-go, without giving up
+// stream.Execute's peer management.
+package grpcstream
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gosharplite/oklog/pkg/stream"
+)
+
+// GRPCReaderFactory returns a stream.ReaderFactory that dials addr via
+// dialer, opens a Tail RPC for query, and adapts the resulting record
+// stream into a stream.FramedReader that hands each Record straight to
+// stream.Execute, untouched by any re-serialization.
+//
+// dialer is invoked on every call to the returned ReaderFactory, i.e. once
+// per connection attempt to addr, and the resulting conn is closed when that
+// attempt ends. dialer is responsible for any DialOptions the caller needs,
+// e.g. grpc.WithTransportCredentials for TLS/mTLS or
+// grpc.WithKeepaliveParams for keepalives.
+func GRPCReaderFactory(dialer func(addr string) (*grpc.ClientConn, error), query string) stream.ReaderFactory {
+	return func(ctx context.Context, addr string) (io.Reader, error) {
+		conn, err := dialer(addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "Dial")
+		}
+		tail, err := NewTailerClient(conn).Tail(ctx, &TailRequest{Query: query})
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "Tail")
+		}
+		// ctx is the peer's long-lived connection manager context, reused
+		// across every reconnect attempt; derive a child per attempt so
+		// this attempt's watcher goroutine below is scoped to tr's own
+		// lifetime instead of accumulating one permanently-parked goroutine
+		// per reconnect until the whole peer is eventually torn down.
+		attemptCtx, cancelAttempt := context.WithCancel(ctx)
+		tr := &tailReader{conn: conn, tail: tail, cancelAttempt: cancelAttempt}
+
+		// readOnce only calls back into tr once it's blocked reading the
+		// next record, so if it instead exits while blocked sending a
+		// previously-decoded record to a full sink (select on ctx.Done()),
+		// tr.Decode is never called again and conn would otherwise leak.
+		// Watching attemptCtx covers both ways this attempt ends: a normal
+		// Recv-error close cancels it via tr.Close(), and the peer's own
+		// ctx being canceled cancels it transitively.
+		go func() {
+			<-attemptCtx.Done()
+			tr.Close()
+		}()
+
+		return tr, nil
+	}
+}
+
+// tailReader adapts a Tailer_TailClient into a stream.FramedReader. It
+// implements io.Reader only so it satisfies stream.ReaderFactory's return
+// type; RecordDecoder is what readOnce actually uses, decoding straight off
+// the RPC instead of through tailReader.Read, so a Record payload
+// containing an embedded '\n' is never split across two records the way
+// re-joining it into a newline-delimited byte stream would.
+type tailReader struct {
+	conn *grpc.ClientConn
+	tail Tailer_TailClient
+
+	cancelAttempt context.CancelFunc
+	closeOnce     sync.Once
+}
+
+// Read implements io.Reader. It's never called in practice: RecordDecoder
+// below takes over decoding for any caller that respects FramedReader.
+func (r *tailReader) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// RecordDecoder implements stream.FramedReader.
+func (r *tailReader) RecordDecoder() stream.RecordDecoder {
+	return grpcRecordDecoder{r}
+}
+
+// Close closes the underlying connection at most once, however it's
+// triggered: a failed Recv, or the peer's ctx being canceled. It also
+// cancels this attempt's own context, so the watcher goroutine started
+// alongside this tailReader exits promptly instead of waiting on the
+// peer's ctx, which may outlive many more reconnect attempts.
+func (r *tailReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		err = r.conn.Close()
+		r.cancelAttempt()
+	})
+	return err
+}
+
+// grpcRecordDecoder decodes records by pulling the next Record directly
+// off the RPC, ignoring the *bufio.Reader readOnce builds around tailReader
+// entirely. That sidesteps re-serializing each Record through a
+// newline-delimited representation, which would corrupt any payload
+// containing an embedded '\n'.
+type grpcRecordDecoder struct {
+	r *tailReader
+}
+
+// Decode implements stream.RecordDecoder.
+func (d grpcRecordDecoder) Decode(*bufio.Reader) ([]byte, error) {
+	rec, err := d.r.tail.Recv()
+	if err != nil {
+		d.r.Close()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if status.Code(err) == codes.Canceled {
+			return nil, context.Canceled
+		}
+		return nil, err
+	}
+	return rec.Data, nil
+}
+
+// RecordSource yields the records a TailServer should stream back for a
+// given query, in order, until ctx is done or the source is exhausted.
+// Implementations are typically backed by a peer's local segment store.
+// Tail must close records before returning, whether it returns because ctx
+// was canceled or because the source ran out of records.
+type RecordSource interface {
+	Tail(ctx context.Context, query string, records chan<- []byte) error
+}
+
+// Server adapts a RecordSource into a TailerServer, so it can be registered
+// on a *grpc.Server alongside oklog's other peer-to-peer RPCs.
+type Server struct {
+	src RecordSource
+}
+
+// NewServer returns a TailerServer backed by src.
+func NewServer(src RecordSource) *Server {
+	return &Server{src: src}
+}
+
+// Tail implements TailerServer by running req's query against the
+// underlying RecordSource and forwarding each record to the client. It
+// returns a nil error when the client or the RecordSource's context is
+// canceled, since that's the normal way a long-lived tail ends; grpc-go
+// maps that back to codes.Canceled for the client, which GRPCReaderFactory's
+// tailReader in turn surfaces as context.Canceled. Only req.Query is passed
+// to the RecordSource; req.Cursor is not used, so every Tail streams from
+// wherever the RecordSource itself starts.
+func (s *Server) Tail(req *TailRequest, stream Tailer_TailServer) error {
+	ctx := stream.Context()
+	records := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() { errc <- s.src.Tail(ctx, req.Query, records) }()
+
+	for {
+		select {
+		case rec, ok := <-records:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return <-errc
+			}
+			if err := stream.Send(&Record{Data: rec}); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNewlineRecordDecoder(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("one\ntwo\nthree"))
+	dec := NewlineRecordDecoder{}
+
+	want := []string{"one\n", "two\n", "three\n"}
+	for _, w := range want {
+		rec, err := dec.Decode(r)
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if string(rec) != w {
+			t.Fatalf("Decode() = %q, want %q", rec, w)
+		}
+	}
+	if _, err := dec.Decode(r); err != io.EOF {
+		t.Fatalf("final Decode() error = %v, want io.EOF", err)
+	}
+}
+
+func TestVarintLengthPrefixedDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	for _, rec := range [][]byte{[]byte("abc\ndef"), []byte("")} {
+		var lenBuf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(lenBuf[:], uint64(len(rec)))
+		buf.Write(lenBuf[:n])
+		buf.Write(rec)
+	}
+
+	r := bufio.NewReader(&buf)
+	dec := VarintLengthPrefixedDecoder{}
+
+	rec, err := dec.Decode(r)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if string(rec) != "abc\ndef" {
+		t.Fatalf("Decode() = %q, want %q (embedded newline must survive intact)", rec, "abc\ndef")
+	}
+
+	rec, err = dec.Decode(r)
+	if err != nil || len(rec) != 0 {
+		t.Fatalf("Decode() = (%q, %v), want (\"\", nil)", rec, err)
+	}
+}
+
+func TestVarintLengthPrefixedDecoderRejectsOversizedLength(t *testing.T) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], 1<<62)
+	r := bufio.NewReader(bytes.NewReader(lenBuf[:n]))
+
+	if _, err := (VarintLengthPrefixedDecoder{}).Decode(r); err == nil {
+		t.Fatal("Decode() with a bogus 1<<62 length returned no error, want a bounded-size error")
+	}
+}
+
+func TestFixedLengthPrefixedDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	rec := []byte("abc\ndef")
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(rec)))
+	buf.Write(hdr[:])
+	buf.Write(rec)
+
+	r := bufio.NewReader(&buf)
+	got, err := (FixedLengthPrefixedDecoder{}).Decode(r)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if string(got) != string(rec) {
+		t.Fatalf("Decode() = %q, want %q", got, rec)
+	}
+}
+
+func TestFixedLengthPrefixedDecoderRejectsOversizedLength(t *testing.T) {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], 0xFFFFFFFF)
+	r := bufio.NewReader(bytes.NewReader(hdr[:]))
+
+	if _, err := (FixedLengthPrefixedDecoder{}).Decode(r); err == nil {
+		t.Fatal("Decode() with a bogus 0xFFFFFFFF length returned no error, want a bounded-size error")
+	}
+}
+
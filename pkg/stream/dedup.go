@@ -0,0 +1,165 @@
+package stream
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ulidLen is the length, in bytes, of a ULID's canonical string encoding,
+// which prefixes every record oklog forwards.
+const ulidLen = 26
+
+// KeyFunc extracts the dedup key from a record.
+type KeyFunc func(record []byte) []byte
+
+// ULIDKeyFunc is the default KeyFunc: it takes the leading ULID, i.e. the
+// first ulidLen bytes of record.
+func ULIDKeyFunc(record []byte) []byte {
+	if len(record) < ulidLen {
+		return record
+	}
+	return record[:ulidLen]
+}
+
+// Deduper drops records it has already seen, keyed by a caller-supplied
+// KeyFunc, within a bounded time/size window: whichever of window or
+// maxKeys is reached first evicts the oldest keys. It's safe for
+// concurrent use.
+type Deduper struct {
+	keyFn   KeyFunc
+	window  time.Duration
+	maxKeys int
+
+	mu   sync.Mutex
+	set  map[string]time.Time
+	ring []string // insertion order, oldest first
+
+	hits   uint64
+	misses uint64
+}
+
+// NewDeduper returns a Deduper that keys records via keyFn and remembers up
+// to maxKeys of them for at most window.
+func NewDeduper(keyFn KeyFunc, window time.Duration, maxKeys int) *Deduper {
+	return &Deduper{
+		keyFn:   keyFn,
+		window:  window,
+		maxKeys: maxKeys,
+		set:     make(map[string]time.Time, maxKeys),
+		ring:    make([]string, 0, maxKeys),
+	}
+}
+
+// NewULIDDeduper returns a Deduper keyed by each record's leading ULID.
+func NewULIDDeduper(window time.Duration, maxKeys int) *Deduper {
+	return NewDeduper(ULIDKeyFunc, window, maxKeys)
+}
+
+// Seen reports whether record's key has already been recorded within the
+// current window, and records it if not.
+func (d *Deduper) Seen(record []byte) bool {
+	key := string(d.keyFn(record))
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.evictLocked(now)
+
+	if _, ok := d.set[key]; ok {
+		d.hits++
+		return true
+	}
+	d.misses++
+	d.insertLocked(key, now)
+	return false
+}
+
+// evictLocked drops every key older than window. The caller must hold d.mu.
+func (d *Deduper) evictLocked(now time.Time) {
+	cutoff := now.Add(-d.window)
+	for len(d.ring) > 0 {
+		oldest := d.ring[0]
+		t, ok := d.set[oldest]
+		if !ok || t.After(cutoff) {
+			break
+		}
+		delete(d.set, oldest)
+		d.ring = d.ring[1:]
+	}
+}
+
+// insertLocked records key as seen at now, evicting the oldest key first if
+// maxKeys has been reached. The caller must hold d.mu.
+func (d *Deduper) insertLocked(key string, now time.Time) {
+	if len(d.ring) >= d.maxKeys {
+		oldest := d.ring[0]
+		delete(d.set, oldest)
+		d.ring = d.ring[1:]
+	}
+	d.set[key] = now
+	d.ring = append(d.ring, key)
+}
+
+// DeduperStats is a snapshot of a Deduper's counters, suitable for
+// exporting as metrics so operators can size window/maxKeys.
+type DeduperStats struct {
+	Hits      uint64
+	Misses    uint64
+	Occupancy int
+}
+
+// Stats returns a snapshot of d's current counters.
+func (d *Deduper) Stats() DeduperStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DeduperStats{Hits: d.hits, Misses: d.misses, Occupancy: len(d.ring)}
+}
+
+// ExecuteDedup behaves exactly like Execute, except every record is passed
+// through dd before reaching sink: records dd.Seen reports as duplicates
+// are dropped rather than forwarded. onPeerGone is forwarded to Execute
+// unchanged, so it composes with e.g. a ResumableHTTPReaderFactory's
+// CursorStore.Forget.
+//
+// onForward is invoked for every record Execute's own readOnce hands to the
+// internal pre-dedup channel, which is the point at which the connection
+// that produced it has durably moved past it; it does not mean the record
+// has cleared dd or reached sink, so callers chaining a resumable reader's
+// CursorStore.OnForward through dedup should resume behind the dedup window
+// rather than rely on exact once-only delivery.
+func ExecuteDedup(
+	ctx context.Context,
+	pf PeerFactory,
+	rf ReaderFactory,
+	sink chan<- []byte,
+	sleep func(time.Duration),
+	ticker func(time.Duration) *time.Ticker,
+	policy BackoffPolicy,
+	obs Observer,
+	dec RecordDecoder,
+	dd *Deduper,
+	onPeerGone func(addr string),
+	onForward func(addr string, rec []byte),
+) {
+	filtered := make(chan []byte)
+	go func() {
+		for {
+			select {
+			case rec := <-filtered:
+				if dd.Seen(rec) {
+					continue
+				}
+				select {
+				case sink <- rec:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	Execute(ctx, pf, rf, filtered, sleep, ticker, policy, obs, dec, onPeerGone, onForward)
+}
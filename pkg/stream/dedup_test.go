@@ -0,0 +1,84 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestULIDKeyFunc(t *testing.T) {
+	ulid := "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+	rec := []byte(ulid + " the rest of the record\n")
+	if got := string(ULIDKeyFunc(rec)); got != ulid {
+		t.Fatalf("ULIDKeyFunc(%q) = %q, want %q", rec, got, ulid)
+	}
+
+	short := []byte("tooshort")
+	if got := string(ULIDKeyFunc(short)); got != string(short) {
+		t.Fatalf("ULIDKeyFunc(%q) = %q, want the record unchanged", short, got)
+	}
+}
+
+func TestDeduperDropsDuplicates(t *testing.T) {
+	d := NewDeduper(func(r []byte) []byte { return r }, time.Minute, 100)
+
+	if d.Seen([]byte("a")) {
+		t.Fatal("first Seen(a) = true, want false (not yet recorded)")
+	}
+	if !d.Seen([]byte("a")) {
+		t.Fatal("second Seen(a) = false, want true (duplicate)")
+	}
+	if d.Seen([]byte("b")) {
+		t.Fatal("first Seen(b) = true, want false (distinct key)")
+	}
+
+	stats := d.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Occupancy != 2 {
+		t.Fatalf("Stats() = %+v, want {Hits:1 Misses:2 Occupancy:2}", stats)
+	}
+}
+
+func TestDeduperEvictsOldestOnMaxKeys(t *testing.T) {
+	d := NewDeduper(func(r []byte) []byte { return r }, time.Minute, 2)
+
+	d.Seen([]byte("a"))
+	d.Seen([]byte("b"))
+	d.Seen([]byte("c")) // evicts "a", the oldest
+
+	// Inspect the ring/set directly rather than probing with Seen: Seen
+	// always records a miss as seen, so using it to check whether "a" was
+	// evicted would itself re-insert "a" and evict "b" as a side effect,
+	// corrupting the very state the test is trying to observe.
+	d.mu.Lock()
+	_, aPresent := d.set["a"]
+	_, bPresent := d.set["b"]
+	_, cPresent := d.set["c"]
+	occupancy := len(d.ring)
+	d.mu.Unlock()
+
+	if aPresent {
+		t.Fatal("\"a\" still present after maxKeys eviction, want it evicted as the oldest")
+	}
+	if !bPresent {
+		t.Fatal("\"b\" evicted, want it still remembered")
+	}
+	if !cPresent {
+		t.Fatal("\"c\" evicted, want it still remembered")
+	}
+	if occupancy > 2 {
+		t.Fatalf("Occupancy = %d, want <= 2 (maxKeys)", occupancy)
+	}
+}
+
+func TestDeduperEvictsExpiredByWindow(t *testing.T) {
+	d := NewDeduper(func(r []byte) []byte { return r }, 10*time.Millisecond, 100)
+
+	d.Seen([]byte("a"))
+	time.Sleep(30 * time.Millisecond)
+
+	// A second key's insertion triggers the expiry sweep.
+	d.Seen([]byte("b"))
+
+	if d.Seen([]byte("a")) {
+		t.Fatal("Seen(a) = true after its window elapsed, want false (should have expired)")
+	}
+}
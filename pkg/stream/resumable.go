@@ -0,0 +1,128 @@
+package stream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CursorStore tracks, per peer address, the leading ULID of the last
+// record a ResumableHTTPReaderFactory reader successfully forwarded. It's
+// safe for concurrent use; pass its Forget method as Execute's onPeerGone
+// so a departed peer's cursor doesn't linger forever.
+type CursorStore struct {
+	mu      sync.Mutex
+	cursors map[string]string
+}
+
+// NewCursorStore returns an empty CursorStore.
+func NewCursorStore() *CursorStore {
+	return &CursorStore{cursors: map[string]string{}}
+}
+
+// Get returns the last cursor recorded for addr, if any.
+func (c *CursorStore) Get(addr string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cursor, ok := c.cursors[addr]
+	return cursor, ok
+}
+
+// Set records cursor as the last ULID successfully forwarded for addr.
+func (c *CursorStore) Set(addr, cursor string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cursors[addr] = cursor
+}
+
+// Forget discards addr's cursor. It matches the onPeerGone signature
+// Execute expects.
+func (c *CursorStore) Forget(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cursors, addr)
+}
+
+// OnForward records rec's leading ULID as addr's cursor. It matches the
+// onForward signature Execute expects, so passing it straight through to
+// Execute keeps the cursor exactly in step with what's actually been handed
+// to sink: Execute only calls onForward once a record has cleared the
+// select on sink, never for one merely decoded off the wire, so a reconnect
+// can never resume past a record the caller didn't receive.
+func (c *CursorStore) OnForward(addr string, rec []byte) {
+	if len(rec) < ulidLen {
+		return
+	}
+	c.Set(addr, string(rec[:ulidLen]))
+}
+
+// ResumableHTTPReaderFactory returns a ReaderFactory like HTTPReaderFactory,
+// except it consults store for the last ULID successfully forwarded for
+// addr and, if present, asks the server to resume just past it via a
+// "Range: ulid=<lastULID>-" header, so a reconnect doesn't replay records
+// the caller has already received. Pass store.OnForward as Execute's
+// onForward so the cursor itself is kept current from the decode/forward
+// path in stream.go, not from bytes read ahead off the wire.
+func ResumableHTTPReaderFactory(client *http.Client, addr2url func(string) string, store *CursorStore) ReaderFactory {
+	return func(ctx context.Context, addr string) (io.Reader, error) {
+		req, err := http.NewRequest("GET", addr2url(addr), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewRequest")
+		}
+		req.Header.Set("Accept", ContentTypeFramed+", "+ContentTypeFixed32+", "+ContentTypeNewline)
+		if cursor, ok := store.Get(addr); ok {
+			req.Header.Set("Range", "ulid="+cursor+"-")
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, errors.Wrap(err, "Do")
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return nil, errors.Errorf("GET: %s", resp.Status)
+		}
+
+		return httpFramedBody{
+			ReadCloser: resp.Body,
+			dec:        decoderForContentType(resp.Header.Get("Content-Type")),
+		}, nil
+	}
+}
+
+// ResumeSeeker is implemented by a peer's local segment store, letting it
+// resume a streaming response partway through a ULID-ordered sequence of
+// records instead of replaying everything from the start.
+type ResumeSeeker interface {
+	// SeekULID returns a reader positioned at the record immediately after
+	// the one identified by ulid.
+	SeekULID(ulid string) (io.Reader, error)
+}
+
+// ParseResumeCursor extracts a resume cursor from r, checking the
+// "Range: ulid=<lastULID>-" header first and falling back to a "from"
+// query parameter. It reports ok=false if neither is present.
+func ParseResumeCursor(r *http.Request) (ulid string, ok bool) {
+	if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "ulid=") {
+		if u := strings.TrimSuffix(strings.TrimPrefix(rng, "ulid="), "-"); u != "" {
+			return u, true
+		}
+	}
+	if u := r.URL.Query().Get("from"); u != "" {
+		return u, true
+	}
+	return "", false
+}
+
+// SeekResumable resolves r's resume cursor, if any, against seeker,
+// returning the reader a server-side streaming handler should copy to the
+// response. If r carries no cursor, it returns fromStart unchanged.
+func SeekResumable(r *http.Request, seeker ResumeSeeker, fromStart io.Reader) (io.Reader, error) {
+	ulid, ok := ParseResumeCursor(r)
+	if !ok {
+		return fromStart, nil
+	}
+	return seeker.SeekULID(ulid)
+}
@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testULID1 = "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+const testULID2 = "01ARZ3NDEKTSV4RRFFQ69G5FAW"
+
+func TestCursorStoreOnForwardRecordsLeadingULID(t *testing.T) {
+	store := NewCursorStore()
+	store.OnForward("addr", []byte(testULID1+" hello\n"))
+
+	got, ok := store.Get("addr")
+	if !ok || got != testULID1 {
+		t.Fatalf("Get() = (%q, %v), want (%q, true)", got, ok, testULID1)
+	}
+}
+
+func TestCursorStoreOnForwardIgnoresShortRecords(t *testing.T) {
+	store := NewCursorStore()
+	store.OnForward("addr", []byte("short"))
+
+	if _, ok := store.Get("addr"); ok {
+		t.Fatal("Get() ok = true after a record shorter than a ULID, want false")
+	}
+}
+
+func TestCursorStoreOnForwardOnlyAdvancesOnActualDelivery(t *testing.T) {
+	// readOnce must call onForward only once a record clears the select on
+	// sink, never merely once it's decoded off the wire: otherwise a
+	// reconnect could resume past a record the caller never received. A
+	// sink with room for exactly one record, and no reader draining it,
+	// forces the second record to block until ctx is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rf := func(context.Context, string) (io.Reader, error) {
+		return strings.NewReader(testULID1 + " one\n" + testULID2 + " two\n"), nil
+	}
+	sink := make(chan []byte, 1)
+	store := NewCursorStore()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	n, err := readOnce(ctx, rf, "addr", sink, NewlineRecordDecoder{}, store.OnForward)
+	if err != context.Canceled {
+		t.Fatalf("readOnce() error = %v, want context.Canceled", err)
+	}
+	if n != 1 {
+		t.Fatalf("readOnce() n = %d, want 1", n)
+	}
+
+	got, ok := store.Get("addr")
+	if !ok || got != testULID1 {
+		t.Fatalf("Get() = (%q, %v), want (%q, true): the cursor must not advance past the record still stuck behind the full sink", got, ok, testULID1)
+	}
+}
+
+func TestParseResumeCursorFromRangeHeader(t *testing.T) {
+	req := &http.Request{Header: http.Header{}, URL: &url.URL{}}
+	req.Header.Set("Range", "ulid="+testULID1+"-")
+
+	got, ok := ParseResumeCursor(req)
+	if !ok || got != testULID1 {
+		t.Fatalf("ParseResumeCursor() = (%q, %v), want (%q, true)", got, ok, testULID1)
+	}
+}
+
+func TestParseResumeCursorFromQueryParam(t *testing.T) {
+	u, err := url.Parse("http://example.com/stream?from=" + testULID2)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	req := &http.Request{Header: http.Header{}, URL: u}
+
+	got, ok := ParseResumeCursor(req)
+	if !ok || got != testULID2 {
+		t.Fatalf("ParseResumeCursor() = (%q, %v), want (%q, true)", got, ok, testULID2)
+	}
+}
+
+func TestParseResumeCursorAbsent(t *testing.T) {
+	req := &http.Request{Header: http.Header{}, URL: &url.URL{}}
+	if _, ok := ParseResumeCursor(req); ok {
+		t.Fatal("ParseResumeCursor() ok = true with neither Range nor from set, want false")
+	}
+}
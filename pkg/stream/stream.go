@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"io"
+	"math/rand"
 	"net/http"
 	"time"
 
@@ -20,13 +21,159 @@ type PeerFactory func() []string
 // Other errors will cause the managing goroutine to reconstruct the reader.
 type ReaderFactory func(context.Context, string) (io.Reader, error)
 
+// BackoffPolicy configures how readUntilCanceled waits between reconnect
+// attempts to a single peer, and when it trips the per-peer circuit
+// breaker. A zero-value BackoffPolicy is replaced by DefaultBackoffPolicy;
+// otherwise zero BaseDelay, MaxDelay and ProbeInterval fields fall back to
+// the corresponding DefaultBackoffPolicy field, while a zero
+// FailureThreshold is kept and disables the circuit breaker.
+type BackoffPolicy struct {
+	// BaseDelay is the minimum delay before the first retry, and the floor
+	// of every subsequent backoff computation.
+	BaseDelay time.Duration
+
+	// MaxDelay is the upper bound on any single backoff delay.
+	MaxDelay time.Duration
+
+	// FailureThreshold is the number of consecutive failures required to
+	// open the circuit for a peer. Zero disables the circuit breaker.
+	FailureThreshold int
+
+	// ProbeInterval is how long the circuit stays open before a single
+	// half-open probe is allowed through.
+	ProbeInterval time.Duration
+}
+
+// DefaultBackoffPolicy is used by Execute when the caller supplies a zero
+// value BackoffPolicy.
+var DefaultBackoffPolicy = BackoffPolicy{
+	BaseDelay:        250 * time.Millisecond,
+	MaxDelay:         30 * time.Second,
+	FailureThreshold: 5,
+	ProbeInterval:    30 * time.Second,
+}
+
+// withDefaults fills in a caller-supplied BackoffPolicy. A zero-value p
+// (the common case of "I don't care, just use sane defaults") is replaced
+// wholesale by DefaultBackoffPolicy. Otherwise, only BaseDelay, MaxDelay,
+// and ProbeInterval are defaulted when left at zero; FailureThreshold is
+// taken as given, including zero, since zero is how a caller disables the
+// circuit breaker (see its doc comment).
+func (p BackoffPolicy) withDefaults() BackoffPolicy {
+	if p == (BackoffPolicy{}) {
+		return DefaultBackoffPolicy
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultBackoffPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultBackoffPolicy.MaxDelay
+	}
+	if p.ProbeInterval <= 0 {
+		p.ProbeInterval = DefaultBackoffPolicy.ProbeInterval
+	}
+	return p
+}
+
+// next computes the next backoff delay given the previous one, using
+// decorrelated jitter: sleep = min(cap, random_between(base, prev*3)).
+func (p BackoffPolicy) next(prev time.Duration) time.Duration {
+	if prev < p.BaseDelay {
+		prev = p.BaseDelay
+	}
+	hi := prev * 3
+	if hi > p.MaxDelay {
+		hi = p.MaxDelay
+	}
+	span := hi - p.BaseDelay
+	if span <= 0 {
+		return p.BaseDelay
+	}
+	d := p.BaseDelay + time.Duration(rand.Int63n(int64(span)))
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// CircuitState describes the state of a peer's circuit breaker, as reported
+// to an Observer.
+type CircuitState int
+
+const (
+	// CircuitClosed is the normal state: reconnects are attempted with
+	// backoff after every failure.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen means the peer has failed FailureThreshold times in a
+	// row; readOnce is not called again until ProbeInterval elapses.
+	CircuitOpen
+
+	// CircuitHalfOpen means ProbeInterval has elapsed and a single probe
+	// connection is being attempted to decide whether to close the
+	// circuit again.
+	CircuitHalfOpen
+)
+
+// String implements fmt.Stringer.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerState is a snapshot of a single peer's reconnect/backoff state, as
+// reported to an Observer after every connection attempt.
+type PeerState struct {
+	Addr         string
+	Attempts     int // consecutive failures since the last success
+	LastError    error
+	NextRetry    time.Time
+	CircuitState CircuitState
+}
+
+// Observer receives updates about the per-peer connection state managed by
+// Execute. Observe is invoked synchronously from the peer's connection
+// manager goroutine, so implementations should return quickly.
+type Observer interface {
+	Observe(PeerState)
+}
+
+// NopObserver is an Observer that discards every state update.
+var NopObserver Observer = nopObserver{}
+
+type nopObserver struct{}
+
+func (nopObserver) Observe(PeerState) {}
+
 // Execute creates and maintains streams of records to multiple peers.
 // It blocks until the parent context is canceled.
 // It's designed to be invoked once per user stream request.
 //
 // Incoming records are muxed onto the provided sink chan.
-// The sleep func is used to backoff between retries of a single peer.
+// The sleep func is used to back off between retries of a single peer,
+// paced by policy; a zero value BackoffPolicy uses DefaultBackoffPolicy.
 // The ticker func is used to regularly resolve peers.
+// obs, if non-nil, is notified of per-peer state after every connection
+// attempt; pass NopObserver to ignore it.
+// dec decodes each peer's byte stream into discrete records; a nil dec
+// defaults to NewlineRecordDecoder, but a ReaderFactory can override it
+// per-connection by returning a FramedReader.
+// onPeerGone, if non-nil, is called with a peer's address once it stops
+// being returned by pf, so a ReaderFactory that keeps per-address state
+// (e.g. a resumable reader's CursorStore) can discard it.
+// onForward, if non-nil, is called with a peer's address and a record
+// immediately after that record is handed to sink, i.e. once it's actually
+// been delivered rather than merely decoded off the wire; a resumable
+// reader's CursorStore.OnForward is meant to be passed here so its cursor
+// never advances past a record the caller hasn't received.
 func Execute(
 	ctx context.Context,
 	pf PeerFactory,
@@ -34,10 +181,29 @@ func Execute(
 	sink chan<- []byte,
 	sleep func(time.Duration),
 	ticker func(time.Duration) *time.Ticker,
+	policy BackoffPolicy,
+	obs Observer,
+	dec RecordDecoder,
+	onPeerGone func(addr string),
+	onForward func(addr string, rec []byte),
 ) {
+	if obs == nil {
+		obs = NopObserver
+	}
+	if dec == nil {
+		dec = NewlineRecordDecoder{}
+	}
+	if onPeerGone == nil {
+		onPeerGone = func(string) {}
+	}
+	if onForward == nil {
+		onForward = func(string, []byte) {}
+	}
+	policy = policy.withDefaults()
+
 	// Invoke the PeerFactory to get the initial addrs.
 	// Initialize connection managers to each of them.
-	active := updateActive(ctx, nil, pf(), rf, sink, sleep)
+	active := updateActive(ctx, nil, pf(), rf, sink, sleep, policy, obs, dec, onPeerGone, onForward)
 
 	// Re-invoke the peerFactory every second.
 	tk := ticker(time.Second)
@@ -48,7 +214,7 @@ func Execute(
 		case <-tk.C:
 			// Detect new peers, and create connection managers for them.
 			// Terminate connection managers for peers that have gone away.
-			active = updateActive(ctx, active, pf(), rf, sink, sleep) // update
+			active = updateActive(ctx, active, pf(), rf, sink, sleep, policy, obs, dec, onPeerGone, onForward) // update
 
 		case <-ctx.Done():
 			// Context cancelation is transitive.
@@ -65,6 +231,11 @@ func updateActive(
 	rf ReaderFactory,
 	sink chan<- []byte,
 	sleep func(time.Duration),
+	policy BackoffPolicy,
+	obs Observer,
+	dec RecordDecoder,
+	onPeerGone func(addr string),
+	onForward func(addr string, rec []byte),
 ) map[string]func() {
 	// Create the "new" collection of peer managers.
 	// Really, we just have to track the cancel func.
@@ -81,15 +252,16 @@ func updateActive(
 			// This addr appears to be new!
 			// Create a new connection manager for it.
 			ctx, cancel := context.WithCancel(parent)
-			go readUntilCanceled(ctx, rf, addr, sink, sleep)
+			go readUntilCanceled(ctx, rf, addr, sink, sleep, policy, obs, dec, onForward)
 			nextgen[addr] = cancel
 		}
 	}
 
 	// All the addrs left over in the previous collection are gone.
-	// Their connection managers should be canceled.
-	for _, cancel := range prevgen {
+	// Their connection managers should be canceled, and the caller notified.
+	for addr, cancel := range prevgen {
 		cancel()
+		onPeerGone(addr)
 	}
 
 	// Good to go.
@@ -98,44 +270,149 @@ func updateActive(
 
 // readUntilCanceled is a kind of connection manager to the given addr.
 // We connect to addr via the factory, read records, and put them on the sink.
-// Any connection error causes us to wait a second and then reconnect.
+// Failures are paced with policy's exponential backoff and jitter; once
+// FailureThreshold consecutive failures accrue, the circuit opens and
+// readOnce is skipped until ProbeInterval elapses, at which point a single
+// half-open probe decides whether to close the circuit again.
 // readUntilCanceled blocks until the context is canceled.
-func readUntilCanceled(ctx context.Context, rf ReaderFactory, addr string, sink chan<- []byte, sleep func(time.Duration)) {
+func readUntilCanceled(ctx context.Context, rf ReaderFactory, addr string, sink chan<- []byte, sleep func(time.Duration), policy BackoffPolicy, obs Observer, dec RecordDecoder, onForward func(addr string, rec []byte)) {
+	var (
+		attempts  int
+		delay     = policy.BaseDelay
+		circuit   = CircuitClosed
+		nextRetry time.Time
+	)
+
 	for {
-		switch readOnce(ctx, rf, addr, sink) {
-		case context.Canceled:
+		if circuit == CircuitOpen {
+			obs.Observe(PeerState{Addr: addr, Attempts: attempts, NextRetry: nextRetry, CircuitState: circuit})
+
+			// Wait for the probe interval to elapse, but return promptly
+			// if ctx is canceled in the meantime instead of ignoring
+			// cancellation for up to ProbeInterval.
+			timer := time.NewTimer(time.Until(nextRetry))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			circuit = CircuitHalfOpen
+		}
+
+		n, err := readOnce(ctx, rf, addr, sink, dec, onForward)
+		switch {
+		case err == context.Canceled:
 			return
+
+		case err == nil && n > 0:
+			// A successful read of at least one record resets the breaker.
+			attempts, delay, circuit = 0, policy.BaseDelay, CircuitClosed
+			obs.Observe(PeerState{Addr: addr, Attempts: attempts, CircuitState: circuit})
+			continue
+
+		case err == nil && n == 0:
+			// The peer connected cleanly but had nothing new to send (e.g.
+			// an idle long-poll). That's not a failure: leave attempts,
+			// delay, and the breaker untouched so a merely-idle peer never
+			// trips the circuit, and just reconnect at the base delay.
+			obs.Observe(PeerState{Addr: addr, Attempts: attempts, CircuitState: circuit})
+			sleep(policy.BaseDelay)
+			continue
+
 		default:
-			sleep(time.Second)
+			attempts++
+			delay = policy.next(delay)
+
+			if policy.FailureThreshold > 0 && attempts >= policy.FailureThreshold {
+				circuit = CircuitOpen
+				nextRetry = time.Now().Add(policy.ProbeInterval)
+			} else if circuit == CircuitHalfOpen {
+				// The probe failed; reopen the circuit.
+				circuit = CircuitOpen
+				nextRetry = time.Now().Add(policy.ProbeInterval)
+			}
+
+			obs.Observe(PeerState{Addr: addr, Attempts: attempts, LastError: err, NextRetry: nextRetry, CircuitState: circuit})
+			sleep(delay)
 		}
 	}
 }
 
-func readOnce(ctx context.Context, rf ReaderFactory, addr string, sink chan<- []byte) error {
+// readOnce connects to addr via rf, decodes records from the resulting
+// reader via dec (or the RecordDecoder returned by the reader itself, if it
+// implements FramedReader), and forwards each onto sink. It returns the
+// number of records forwarded and the error that ended the read, which is
+// nil on a clean EOF, context.Canceled if ctx was canceled, or the
+// underlying decode error otherwise.
+// onForward is called with addr and rec immediately after rec is handed to
+// sink, never before: a record that's decoded but never delivered (e.g. ctx
+// is canceled while readOnce is blocked on a full sink) must never be
+// reported as forwarded.
+func readOnce(ctx context.Context, rf ReaderFactory, addr string, sink chan<- []byte, dec RecordDecoder, onForward func(addr string, rec []byte)) (int, error) {
 	r, err := rf(ctx, addr)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if fr, ok := r.(FramedReader); ok {
+		dec = fr.RecordDecoder()
 	}
-	s := bufio.NewScanner(r)
-	for s.Scan() {
+	var n int
+	br := bufio.NewReader(r)
+	for {
+		rec, err := dec.Decode(br)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
 		select {
-		case sink <- append(s.Bytes(), '\n'):
+		case sink <- rec:
+			n++
+			onForward(addr, rec)
 		case <-ctx.Done():
-			return ctx.Err()
+			return n, ctx.Err()
 		}
 	}
-	return s.Err()
+}
+
+// Content-Type values used to negotiate a peer's record framing over HTTP.
+// A server advertises its chosen framing with a Content-Type response
+// header; HTTPReaderFactory maps that back to a RecordDecoder.
+const (
+	ContentTypeNewline = "text/plain"
+	ContentTypeFramed  = "application/vnd.oklog.records+framed"
+	ContentTypeFixed32 = "application/vnd.oklog.records+fixed32"
+)
+
+// decoderForContentType returns the RecordDecoder matching a negotiated
+// Content-Type, defaulting to NewlineRecordDecoder for anything else.
+func decoderForContentType(contentType string) RecordDecoder {
+	switch contentType {
+	case ContentTypeFramed:
+		return VarintLengthPrefixedDecoder{}
+	case ContentTypeFixed32:
+		return FixedLengthPrefixedDecoder{}
+	default:
+		return NewlineRecordDecoder{}
+	}
 }
 
 // HTTPReaderFactory returns a ReaderFactory that converts the addr to a URL via
 // the addr2url function, makes a GET request via the client, and returns the
-// response body as the reader.
+// response body as the reader. The request advertises support for framed
+// record encodings via an Accept header, and the returned reader carries
+// whichever RecordDecoder matches the response's Content-Type, so callers
+// that pass it to Execute get correct decoding without configuring it
+// out of band.
 func HTTPReaderFactory(client *http.Client, addr2url func(string) string) ReaderFactory {
 	return func(ctx context.Context, addr string) (io.Reader, error) {
 		req, err := http.NewRequest("GET", addr2url(addr), nil)
 		if err != nil {
 			return nil, errors.Wrap(err, "NewRequest")
 		}
+		req.Header.Set("Accept", ContentTypeFramed+", "+ContentTypeFixed32+", "+ContentTypeNewline)
 		resp, err := client.Do(req.WithContext(ctx))
 		if err != nil {
 			return nil, errors.Wrap(err, "Do")
@@ -143,6 +420,19 @@ func HTTPReaderFactory(client *http.Client, addr2url func(string) string) Reader
 		if resp.StatusCode != http.StatusOK {
 			return nil, errors.Errorf("GET: %s", resp.Status)
 		}
-		return resp.Body, nil
+		return httpFramedBody{
+			ReadCloser: resp.Body,
+			dec:        decoderForContentType(resp.Header.Get("Content-Type")),
+		}, nil
 	}
 }
+
+// httpFramedBody pairs an HTTP response body with the RecordDecoder
+// negotiated via its Content-Type, implementing FramedReader.
+type httpFramedBody struct {
+	io.ReadCloser
+	dec RecordDecoder
+}
+
+// RecordDecoder implements FramedReader.
+func (b httpFramedBody) RecordDecoder() RecordDecoder { return b.dec }
@@ -0,0 +1,170 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicyWithDefaultsZeroValue(t *testing.T) {
+	got := BackoffPolicy{}.withDefaults()
+	if got != DefaultBackoffPolicy {
+		t.Fatalf("zero-value BackoffPolicy.withDefaults() = %+v, want %+v", got, DefaultBackoffPolicy)
+	}
+}
+
+func TestBackoffPolicyWithDefaultsHonorsExplicitZeroFailureThreshold(t *testing.T) {
+	p := BackoffPolicy{
+		BaseDelay:        time.Millisecond,
+		MaxDelay:         time.Second,
+		FailureThreshold: 0,
+		ProbeInterval:    time.Second,
+	}
+	got := p.withDefaults()
+	if got.FailureThreshold != 0 {
+		t.Fatalf("FailureThreshold = %d, want 0 (an explicit zero must disable the circuit breaker)", got.FailureThreshold)
+	}
+}
+
+func TestBackoffPolicyNextStaysWithinBounds(t *testing.T) {
+	p := BackoffPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+	prev := p.BaseDelay
+	for i := 0; i < 100; i++ {
+		d := p.next(prev)
+		if d < p.BaseDelay || d > p.MaxDelay {
+			t.Fatalf("next(%v) = %v, want within [%v, %v]", prev, d, p.BaseDelay, p.MaxDelay)
+		}
+		prev = d
+	}
+}
+
+// fakeObserver records every PeerState it's given, for assertions.
+type fakeObserver struct {
+	mu     sync.Mutex
+	states []PeerState
+}
+
+func (f *fakeObserver) Observe(s PeerState) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states = append(f.states, s)
+}
+
+func (f *fakeObserver) last() PeerState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.states) == 0 {
+		return PeerState{}
+	}
+	return f.states[len(f.states)-1]
+}
+
+func (f *fakeObserver) maxAttempts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	max := 0
+	for _, s := range f.states {
+		if s.Attempts > max {
+			max = s.Attempts
+		}
+	}
+	return max
+}
+
+func TestReadUntilCanceledIdleCleanEOFIsNotAFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A peer that always connects cleanly but never has anything new to
+	// send, like an idle long-poll.
+	rf := func(ctx context.Context, addr string) (io.Reader, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return strings.NewReader(""), nil
+	}
+	sink := make(chan []byte)
+	obs := &fakeObserver{}
+
+	var sleeps int
+	sleep := func(time.Duration) {
+		sleeps++
+		if sleeps >= 10 {
+			cancel()
+		}
+	}
+	policy := BackoffPolicy{
+		BaseDelay:        time.Microsecond,
+		MaxDelay:         time.Millisecond,
+		FailureThreshold: 3,
+		ProbeInterval:    time.Millisecond,
+	}.withDefaults()
+
+	done := make(chan struct{})
+	go func() {
+		readUntilCanceled(ctx, rf, "addr", sink, sleep, policy, obs, NewlineRecordDecoder{}, nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("readUntilCanceled did not return after cancellation")
+	}
+
+	if got := obs.maxAttempts(); got != 0 {
+		t.Fatalf("attempts reached %d against an idle-but-healthy peer, want 0: idle EOF must not count as a failure", got)
+	}
+	if got := obs.last().CircuitState; got != CircuitClosed {
+		t.Fatalf("CircuitState = %v, want %v", got, CircuitClosed)
+	}
+}
+
+func TestReadUntilCanceledOpensCircuitAfterFailureThreshold(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rf := func(ctx context.Context, addr string) (io.Reader, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("boom")
+	}
+	sink := make(chan []byte)
+	obs := &fakeObserver{}
+	sleep := func(time.Duration) {}
+	policy := BackoffPolicy{
+		BaseDelay:        time.Microsecond,
+		MaxDelay:         time.Millisecond,
+		FailureThreshold: 3,
+		ProbeInterval:    time.Minute,
+	}.withDefaults()
+
+	done := make(chan struct{})
+	go func() {
+		readUntilCanceled(ctx, rf, "addr", sink, sleep, policy, obs, NewlineRecordDecoder{}, nil)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for obs.last().CircuitState != CircuitOpen {
+		if time.Now().After(deadline) {
+			t.Fatal("circuit never opened after FailureThreshold consecutive failures")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := obs.maxAttempts(); got < policy.FailureThreshold {
+		t.Fatalf("attempts = %d when circuit opened, want >= FailureThreshold (%d)", got, policy.FailureThreshold)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("readUntilCanceled did not return after cancellation")
+	}
+}